@@ -31,16 +31,20 @@ IMPLEMENTATION NOTES
     Lines are always normalized.  The underlying net/textproto implementation
     has no way to turn this off.
 
-    Protocol tracing is not supported as there is no support for it in either
-    the net/smtp package or the underlying net/textproto pkg.  AFAIK golang
-    is the only standard library SMTP that fails to support tracing.
-
-    net/smtp requires the remote server in ssh-style hostname:port notation.
-    An ordinary user would reasonably expect the same notation available on
-    the command line.  However, net/smtp's PlainAuth authenticator requires
-    the hostname alone.  Rather than adding a bunch of GoLang-specific code
-    to parse around this inconsistency, this implementation just follows the
-    specification, and allows setting the port number only via the -p option.
+    SMTP client duties are handled by internal/smtpx, a fork of the standard
+    library's net/smtp, rather than net/smtp itself.  net/smtp is frozen and
+    not accepting new features, and is missing things this command needs:
+    protocol tracing for -v, and an EHLO failure path that doesn't discard
+    the server's actual error.  See internal/smtpx's package doc for the
+    details.
+
+    net/smtp (and by inheritance smtpx) requires the remote server in
+    ssh-style hostname:port notation.  An ordinary user would reasonably
+    expect the same notation available on the command line.  However, the
+    PlainAuth authenticator requires the hostname alone.  Rather than adding
+    a bunch of GoLang-specific code to parse around this inconsistency, this
+    implementation just follows the specification, and allows setting the
+    port number only via the -p option.
 
 BUGS
     The password prompt does not disable echo.  This is a horrible security
@@ -49,10 +53,6 @@ BUGS
     much later versions of Go than what is bundled with Mint 17.  It would
     be possible by raw termio manipulation, but that seems a bit much for
     what is supposed to be a didactic exercise in the language.
-
-    net/smtp handles errors on EHLO poorly, discarding the error (which most
-    likely contains root cause) and retrying with HELO even on errors other
-    than 500.  (This is a common bug across many language libraries.)
 */
 
 package main
@@ -63,12 +63,13 @@ import (
     "bytes"
     "errors"
     "fmt"
+    "github.com/alameth/hello-smtp/internal/sasl"
+    "github.com/alameth/hello-smtp/internal/smtpx"
     "github.com/pborman/getopt"
     "io"
     "log"
     "net"
     "net/mail"
-    "net/smtp"
     "os"
     "runtime"
     "strings"
@@ -91,12 +92,17 @@ Options:`
 
 // Command line options
 //
+var optMechanism = getopt.String('A', "", "Select SASL mechanism: plain, login, cram-md5, xoauth2, external (default: negotiate)", "mech")
 var optAbortAnyBad = getopt.Bool('a', "Stop (abort) if any recipients are rejected")
 var optIgnoreAllBad = getopt.Bool('c', "Continue even if all recipients are rejected")
 var optDisconnect = getopt.Bool('d', "Disconnect between messages")
 var optMailfrom = getopt.String('f', "", "Specify the sender address", "mailfrom")
 var optEnvFromHeader = getopt.Bool('F', "Get envelope from message header")
 var optHeloName = getopt.String('H', "", "Manually set the client's hostname (for EHLO)", "name")
+var optDSN = getopt.String('N', "", "Request DSN: comma-combined never,success,failure,delay", "dsn")
+var optEnvID = getopt.String('E', "", "Set the DSN envelope ID (ENVID)", "envid")
+var optDSNRet = getopt.StringLong("dsn-ret", 0, "", "Request DSN RET=HDRS or RET=FULL on MAIL FROM", "HDRS|FULL")
+var optRequireDSN = getopt.BoolLong("require-dsn", 0, "Abort instead of dropping -N/-E/--dsn-ret with a warning if the server doesn't advertise DSN")
 var optPort = getopt.String('p', "25", "Override the default port number of 25", "port")
 var optPassword = getopt.String('P', "", "Set password for SMTP authantication", "password")
 var optRecipients = getopt.List('r', "Specify recipient addresses", "recipient")
@@ -107,6 +113,17 @@ var optUseTLS = getopt.Bool('T', "Use TLS Encryption, with fallback to cleartext
 var optUser = getopt.String('U', "", "Use SMTP authentication", "username")
 var optVerbose = getopt.Bool('v', "Write activity to stdout")
 
+// Server-mode (-L) options.  These are only consulted when -L is set; they
+// configure the small submission server instead of the outbound client.
+//
+var optListen = getopt.String('L', "", "Run as a submission server instead, listening on addr:port", "addr:port")
+var optMaildir = getopt.StringLong("maildir", 0, "", "Deliver received messages into this Maildir++ directory", "dir")
+var optPipeCmd = getopt.StringLong("pipe", 0, "", "Pipe each received message to this command", "cmd")
+var optServerCert = getopt.StringLong("cert", 0, "", "TLS certificate file, to offer STARTTLS", "file")
+var optServerKey = getopt.StringLong("key", 0, "", "TLS private key file, to offer STARTTLS", "file")
+var optAllowList = getopt.StringLong("allow", 0, "", "Per-recipient allow-list file; unlisted recipients get 250 OK but are silently dropped", "file")
+var optMaxSize = getopt.StringLong("max-size", 0, "0", "Advertise and enforce this SIZE limit in bytes (0 means no limit)", "bytes")
+
 // Global errlog, a better way to log errors instead of plain stderr
 var errlog = log.New(os.Stderr, "", 0)
 
@@ -119,7 +136,13 @@ type clientwrapper struct {
     heloname   string
     user       string
     password   string
-    client    *smtp.Client
+    mechanism  string
+    dsnNotify  []string
+    dsnEnvID   string
+    dsnRet     string
+    requireDSN bool
+    logger     smtpx.Logger
+    client    *smtpx.Client
     tlsConfig  tls.Config
 }
 
@@ -212,12 +235,10 @@ func (cw *clientwrapper) Send(filename string, msgin *os.File,
     // errors here result in the connection being dropped.
     //
     if cw.client == nil {
-	if cw.client, err = smtp.Dial(cw.server + ":" + cw.port); err != nil {
+	if cw.client, err = smtpx.Dial(cw.server + ":" + cw.port, cw.logger); err != nil {
 	    errlog.Println(err)
 	    return errors.New("Connection failed")
 	}
-	// Retries with HELO on any error, so if anything goes wrong the
-	// error string is useless (and often empty)
 	if err = cw.client.Hello(cw.heloname); err != nil {
 	    cw.client.Close()
 	    cw.client = nil
@@ -247,12 +268,27 @@ func (cw *clientwrapper) Send(filename string, msgin *os.File,
 	}
 
 	if cw.user != "" {
-	    // net/smtp requires the caller to pick the authenticator to use.
-	    // We use PLAIN because it's the only one that's universal.  Note
-	    // that net/smtp will error out if there's no TLS, and will close
-	    // the connection if the authentication fails.
+	    // Figure out which mechanism to speak: whatever -A asked for, or
+	    // else the strongest one the server actually advertised.  Note
+	    // that most mechanisms will error out if there's no TLS, and the
+	    // connection is closed if the authentication fails.
 	    //
-	    auth := smtp.PlainAuth("", cw.user, cw.password, cw.server)
+	    var advertised []string
+	    if enabled, param := cw.client.Extension("AUTH"); enabled {
+		advertised = strings.Fields(param)
+	    }
+	    mech, err := sasl.Negotiate(advertised, cw.mechanism)
+	    if err != nil {
+		cw.client.Quit()
+		cw.client = nil
+		return err
+	    }
+	    auth, err := sasl.New(mech, cw.server, cw.user, cw.password, cw.password)
+	    if err != nil {
+		cw.client.Quit()
+		cw.client = nil
+		return err
+	    }
 	    err = cw.client.Auth(auth); if err != nil {
 		cw.client.Close()
 		cw.client = nil
@@ -298,16 +334,38 @@ func (cw *clientwrapper) Send(filename string, msgin *os.File,
 	}
     }()
 
-    if err = cw.client.Mail(mailfrom[0]); err != nil {
+    // DSN parameters only make sense if the server advertises the DSN
+    // extension; otherwise warn and submit without them, unless the user
+    // asked for --require-dsn strictness instead.
+    //
+    var dsn *smtpx.DSNOptions
+    if len(cw.dsnNotify) > 0 || cw.dsnEnvID != "" || cw.dsnRet != "" {
+	if enabled, _ := cw.client.Extension("DSN"); enabled {
+	    dsn = &smtpx.DSNOptions{Ret: cw.dsnRet, EnvID: cw.dsnEnvID, Notify: cw.dsnNotify}
+	} else if cw.requireDSN {
+	    errlog.Println("Stopping; server does not advertise DSN")
+	    return nil
+	} else {
+	    errlog.Println("Server does not advertise DSN; submitting without delivery status notifications")
+	}
+    }
+
+    // MailAndRcpts pipelines MAIL FROM and all the RCPT TOs onto the wire
+    // together when the server supports it, which is the whole point of
+    // the historic command-line form for a large distribution-list
+    // expansion: one round trip instead of one per recipient.
+    //
+    rcptErrs, err := cw.client.MailAndRcpts(mailfrom[0], recipients, dsn)
+    if err != nil {
 	errlog.Println(err)
 	return nil
     }
 
     inMailState = true
     nGoodRcpts := 0
-    for _, rcpt := range recipients {
-	if err = cw.client.Rcpt(rcpt); err != nil {
-	    errlog.Println(err)
+    for i, rcptErr := range rcptErrs {
+	if rcptErr != nil {
+	    errlog.Println(recipients[i], rcptErr)
 	    continue
 	}
 	nGoodRcpts++
@@ -386,6 +444,18 @@ func main() {
 	os.Exit(0)
     }
 
+    if getopt.IsSet('L') {
+	// Server mode is a different program entirely -- there's no
+	// mailfrom/recipients/message parsing to infer -- so it's handled
+	// and exited from here rather than falling through to the
+	// submission-client logic below.
+	if err := runServer(*optListen); err != nil {
+	    errlog.Println(err)
+	    os.Exit(-1)
+	}
+	os.Exit(0)
+    }
+
     // Infer which command line style was used based on the options that are
     // present, then check for the required arguments and set the connection
     // parameters accordingly.
@@ -472,30 +542,20 @@ func main() {
     // that a 0-length password is legal (if foolish), so a direct check of the
     // -U flag is needed.
     //
-    // TODO/CRITICAL: Echo should be disabled, but there's no portable way to
-    //    do that natively.  There are user libraries, but they require a much
-    //    later version of GoLang than what's in Mint 17 LTS.
-    //
     user := *optUser
     password := *optPassword
     if user != "" && !getopt.IsSet('P') {
-	fmt.Print("Password: ")
-	if termf, err := os.Open("/dev/tty"); err != nil {
+	var err error
+	if password, err = promptPassword("Password: "); err != nil {
 	    errlog.Println(err)
 	    os.Exit(-1)
-	} else {
-	    reader := bufio.NewReader(termf)
-	    if passline, _, err := reader.ReadLine(); err != nil {
-		errlog.Println(err)
-		os.Exit(-1)
-	    } else {
-		password = string(passline)
-	    }
 	}
     }
 
+    var logger smtpx.Logger
     if *optVerbose {
 	fmt.Println("Client:", heloname, "Server:", server, "TLS:", optUseTLS)
+	logger = smtpx.NewWriterLogger(os.Stdout)
     }
 
     // The clientwrapper object holds all parameters that are reused across
@@ -504,8 +564,18 @@ func main() {
     // The Send() method only returns an error on fatal errors, like connection
     // failure or SMTP AUTH failure.
     //
+    var dsnNotify []string
+    if *optDSN != "" {
+	for _, mech := range strings.Split(*optDSN, ",") {
+	    dsnNotify = append(dsnNotify, strings.ToUpper(strings.TrimSpace(mech)))
+	}
+    }
+
     client := clientwrapper{server: server, port: *optPort,
-	    heloname: heloname, user: user, password: password}
+	    heloname: heloname, user: user, password: password,
+	    mechanism: strings.ToLower(*optMechanism), logger: logger,
+	    dsnNotify: dsnNotify, dsnEnvID: *optEnvID, dsnRet: strings.ToUpper(*optDSNRet),
+	    requireDSN: *optRequireDSN}
 
     if len(filenames) == 0 {
 	// No files, so read from stdin
@@ -0,0 +1,35 @@
+package sasl
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"fmt"
+
+	"github.com/alameth/hello-smtp/internal/smtpx"
+)
+
+type cramMD5Auth struct {
+	username, secret string
+}
+
+// CRAMMD5Auth returns a smtpx.Auth that implements the CRAM-MD5
+// authentication mechanism as defined in RFC 2195. The server sends a
+// challenge string; the response is the username followed by a space and
+// the hex HMAC-MD5 of the challenge keyed with the shared secret, so the
+// secret itself never goes over the wire.
+func CRAMMD5Auth(username, secret string) smtpx.Auth {
+	return &cramMD5Auth{username, secret}
+}
+
+func (a *cramMD5Auth) Start(server *smtpx.ServerInfo) (string, []byte, error) {
+	return "CRAM-MD5", nil, nil
+}
+
+func (a *cramMD5Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	d := hmac.New(md5.New, []byte(a.secret))
+	d.Write(fromServer)
+	return []byte(fmt.Sprintf("%s %x", a.username, d.Sum(nil))), nil
+}
@@ -0,0 +1,36 @@
+package sasl
+
+import (
+	"errors"
+
+	"github.com/alameth/hello-smtp/internal/smtpx"
+)
+
+type externalAuth struct {
+	identity string
+}
+
+// ExternalAuth returns a smtpx.Auth that implements the EXTERNAL mechanism
+// (RFC 4422 appendix A): authentication is established out-of-band, via the
+// client TLS certificate presented during STARTTLS, and the AUTH exchange
+// only carries the identity the client wishes to act as (often empty, to
+// mean "whatever the certificate says").
+func ExternalAuth(identity string) smtpx.Auth {
+	return &externalAuth{identity}
+}
+
+func (a *externalAuth) Start(server *smtpx.ServerInfo) (string, []byte, error) {
+	return "EXTERNAL", []byte(a.identity), nil
+}
+
+func (a *externalAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if more {
+		// Nothing should follow the initial response -- authentication was
+		// already settled by the TLS handshake -- so an unexpected
+		// continuation here means the server isn't speaking EXTERNAL the
+		// way we expect; fail rather than silently going out of sync, same
+		// as smtpx.plainAuth.Next does for the same situation.
+		return nil, errors.New("sasl: unexpected EXTERNAL server challenge")
+	}
+	return nil, nil
+}
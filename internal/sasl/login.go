@@ -0,0 +1,47 @@
+package sasl
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alameth/hello-smtp/internal/smtpx"
+)
+
+type loginAuth struct {
+	username, password string
+}
+
+// LoginAuth returns a smtpx.Auth that implements the (non-standard, but
+// near-universal) AUTH LOGIN mechanism. Unlike PLAIN, the server drives the
+// exchange with two base64-encoded prompts -- conventionally "Username:"
+// and "Password:" -- rather than accepting both values up front, so Next
+// has to look at what the server asked for before deciding what to send
+// back.
+func LoginAuth(username, password string) smtpx.Auth {
+	return &loginAuth{username, password}
+}
+
+func (a *loginAuth) Start(server *smtpx.ServerInfo) (string, []byte, error) {
+	// Same rule smtpx.PlainAuth applies: without TLS (or a localhost
+	// server, where there's no network to snoop), LOGIN is just PLAIN
+	// with extra steps -- the password still goes over the wire as
+	// reversible base64, so refuse to send it in the clear.
+	if !server.TLS && !smtpx.IsLocalhost(server.Name) {
+		return "", nil, errors.New("sasl: unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch prompt := strings.ToLower(strings.TrimSpace(string(fromServer))); {
+	case strings.HasPrefix(prompt, "username"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "password"):
+		return []byte(a.password), nil
+	default:
+		return nil, errors.New("sasl: unexpected LOGIN server prompt: " + string(fromServer))
+	}
+}
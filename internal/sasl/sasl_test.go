@@ -0,0 +1,132 @@
+package sasl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/alameth/hello-smtp/internal/smtpx"
+)
+
+// Known-answer test from RFC 2195 section 3.
+func TestCRAMMD5AuthNext(t *testing.T) {
+	auth := CRAMMD5Auth("tim", "tanstaaftanstaaf")
+	challenge := []byte("<1896.697170952@postoffice.reston.mci.net>")
+
+	resp, err := auth.Next(challenge, true)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	want := "tim b913a602c7eda7a495b4e6e7334d3890"
+	if string(resp) != want {
+		t.Errorf("Next response = %q, want %q", resp, want)
+	}
+
+	if resp, err := auth.Next(nil, false); err != nil || resp != nil {
+		t.Errorf("Next(more=false) = (%q, %v), want (nil, nil)", resp, err)
+	}
+}
+
+func TestLoginAuthNext(t *testing.T) {
+	auth := LoginAuth("user", "pass")
+
+	if _, _, err := auth.Start(&smtpx.ServerInfo{TLS: true}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	resp, err := auth.Next([]byte("Username:"), true)
+	if err != nil || string(resp) != "user" {
+		t.Errorf("Next(Username:) = (%q, %v), want (\"user\", nil)", resp, err)
+	}
+	resp, err = auth.Next([]byte("Password:"), true)
+	if err != nil || string(resp) != "pass" {
+		t.Errorf("Next(Password:) = (%q, %v), want (\"pass\", nil)", resp, err)
+	}
+	if _, err := auth.Next([]byte("Something else:"), true); err == nil {
+		t.Error("Next(unexpected prompt) = nil error, want an error")
+	}
+}
+
+func TestLoginAuthRefusesPlaintext(t *testing.T) {
+	auth := LoginAuth("user", "pass")
+	if _, _, err := auth.Start(&smtpx.ServerInfo{Name: "mail.example.com", TLS: false}); err == nil {
+		t.Error("Start over a plaintext, non-localhost connection succeeded, want an error")
+	}
+	if _, _, err := auth.Start(&smtpx.ServerInfo{Name: "localhost", TLS: false}); err != nil {
+		t.Errorf("Start over plaintext localhost: %v, want nil", err)
+	}
+}
+
+func TestXOAuth2AuthRefusesPlaintext(t *testing.T) {
+	auth := XOAuth2Auth("user@example.com", "sometoken")
+	if _, _, err := auth.Start(&smtpx.ServerInfo{Name: "mail.example.com", TLS: false}); err == nil {
+		t.Error("Start over a plaintext, non-localhost connection succeeded, want an error")
+	}
+	_, resp, err := auth.Start(&smtpx.ServerInfo{Name: "mail.example.com", TLS: true})
+	if err != nil {
+		t.Fatalf("Start over TLS: %v", err)
+	}
+	want := "user=user@example.com\x01auth=Bearer sometoken\x01\x01"
+	if !bytes.Equal(resp, []byte(want)) {
+		t.Errorf("Start response = %q, want %q", resp, want)
+	}
+}
+
+func TestXOAuth2AuthNextParsesServerError(t *testing.T) {
+	auth := XOAuth2Auth("user@example.com", "badtoken").(*xoauth2Auth)
+	errJSON := []byte(`{"status":"400","schemes":"Bearer","scope":"mail"}`)
+
+	resp, err := auth.Next(errJSON, true)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if len(resp) != 0 {
+		t.Errorf("Next response = %q, want empty (RFC 7628 requires an empty reply here)", resp)
+	}
+	if auth.ServerError != "400" {
+		t.Errorf("ServerError = %q, want %q", auth.ServerError, "400")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		name       string
+		advertised []string
+		wanted     string
+		want       string
+		wantErr    bool
+	}{
+		{"explicit plain advertised", []string{"PLAIN", "LOGIN"}, "plain", "plain", false},
+		{"explicit not advertised", []string{"PLAIN"}, "xoauth2", "", true},
+		{"auto picks strongest advertised", []string{"PLAIN", "LOGIN", "CRAM-MD5"}, "", CRAMMD5, false},
+		{"auto falls back to weaker", []string{"PLAIN"}, "", Plain, false},
+		{"auto with nothing advertised", nil, "", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Negotiate(tc.advertised, tc.wanted)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Negotiate(%v, %q) = %q, nil, want an error", tc.advertised, tc.wanted, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Negotiate(%v, %q): %v", tc.advertised, tc.wanted, err)
+			}
+			if got != tc.want {
+				t.Errorf("Negotiate(%v, %q) = %q, want %q", tc.advertised, tc.wanted, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestExternalAuth(t *testing.T) {
+	auth := ExternalAuth("user@example.com")
+	mech, resp, err := auth.Start(&smtpx.ServerInfo{TLS: true})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if mech != "EXTERNAL" || string(resp) != "user@example.com" {
+		t.Errorf("Start = (%q, %q), want (\"EXTERNAL\", \"user@example.com\")", mech, resp)
+	}
+}
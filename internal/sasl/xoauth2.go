@@ -0,0 +1,60 @@
+package sasl
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/alameth/hello-smtp/internal/smtpx"
+)
+
+type xoauth2Auth struct {
+	username, token string
+
+	// ServerError holds the decoded JSON error object from a failed
+	// attempt, if any, so the caller can report more than just the
+	// eventual 535 from the AUTH command.
+	ServerError string
+}
+
+// XOAuth2Auth returns a smtpx.Auth that implements Google's XOAUTH2
+// mechanism, as used by Gmail and other providers that have moved away from
+// password-based AUTH entirely. token is a bearer OAuth2 access token, not
+// a password.
+func XOAuth2Auth(username, token string) smtpx.Auth {
+	return &xoauth2Auth{username: username, token: token}
+}
+
+func (a *xoauth2Auth) Start(server *smtpx.ServerInfo) (string, []byte, error) {
+	// The bearer token is as sensitive as a password -- arguably more,
+	// since it's often longer-lived -- so apply the same unencrypted
+	// connection guard smtpx.PlainAuth and LoginAuth do.
+	if !server.TLS && !smtpx.IsLocalhost(server.Name) {
+		return "", nil, errors.New("sasl: unencrypted connection")
+	}
+	resp := "user=" + a.username + "\x01auth=Bearer " + a.token + "\x01\x01"
+	return "XOAUTH2", []byte(resp), nil
+}
+
+// xoauth2Error is the JSON object Google's servers send back, base64
+// decoded, when the bearer token is rejected; it arrives as a 334
+// continuation rather than the usual 535 failure.
+type xoauth2Error struct {
+	Status  string `json:"status"`
+	Schemes string `json:"schemes"`
+	Scope   string `json:"scope"`
+}
+
+func (a *xoauth2Auth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	// The server is reporting a failure rather than prompting for more
+	// data. Stash whatever it told us, then respond with an empty message
+	// as RFC 7628 requires so the server can return the real 535 failure
+	// rather than leaving the AUTH command hanging.
+	var xerr xoauth2Error
+	if err := json.Unmarshal(fromServer, &xerr); err == nil {
+		a.ServerError = xerr.Status
+	}
+	return []byte{}, nil
+}
@@ -0,0 +1,13 @@
+package smtpx
+
+// DSNOptions carries RFC 3461 delivery status notification parameters:
+// Ret and EnvID are attached to MAIL FROM, Notify governs the NOTIFY=
+// parameter attached to every RCPT TO (which also gets an ORCPT= for that
+// recipient's own address). A nil *DSNOptions, the default, omits all of
+// this and the transaction looks exactly as it did before DSN support
+// existed.
+type DSNOptions struct {
+	Ret    string   // "HDRS" or "FULL"; empty omits RET=
+	EnvID  string   // empty omits ENVID=
+	Notify []string // any of "NEVER", "SUCCESS", "FAILURE", "DELAY"; empty omits NOTIFY=/ORCPT=
+}
@@ -0,0 +1,145 @@
+package smtpx
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+)
+
+// Direction identifies which way a traced line crossed the wire.
+type Direction int
+
+const (
+	// ClientToServer marks a line we sent to the server.
+	ClientToServer Direction = iota
+	// ServerToClient marks a line the server sent to us.
+	ServerToClient
+)
+
+func (d Direction) String() string {
+	if d == ServerToClient {
+		return "S"
+	}
+	return "C"
+}
+
+// Logger receives one call per complete line that crosses the wire, in
+// transmission order. verb is the part before the first space on the
+// line (the command name on the client side, the reply code on the server
+// side); payload is everything after the first space. If the line has no
+// space, verb is the whole line and payload is empty. The trailing CRLF is
+// never included.
+type Logger interface {
+	Log(dir Direction, verb string, payload string)
+}
+
+// LoggerFunc adapts a plain function to the Logger interface.
+type LoggerFunc func(dir Direction, verb string, payload string)
+
+// Log implements Logger.
+func (f LoggerFunc) Log(dir Direction, verb string, payload string) {
+	f(dir, verb, payload)
+}
+
+// NewWriterLogger returns a Logger that writes one "C: " or "S: " prefixed
+// line per traced SMTP line to w, in the style of the protocol traces other
+// language SMTP libraries produce under a -v / --verbose flag.
+func NewWriterLogger(w io.Writer) Logger {
+	return LoggerFunc(func(dir Direction, verb string, payload string) {
+		line := verb
+		if payload != "" {
+			line += " " + payload
+		}
+		io.WriteString(w, dir.String()+": "+line+"\n")
+	})
+}
+
+// tracedConn wraps a net.Conn, reassembling the byte stream written to and
+// read from the peer along CRLF boundaries (which rarely line up with
+// read/write or TCP segment boundaries) and forwarding each completed line
+// to a Logger.
+type tracedConn struct {
+	net.Conn
+	logger Logger
+	rbuf   []byte
+	wbuf   []byte
+
+	// inAuth is true from a client AUTH command up to (but not including)
+	// the server's final reply to it. Every AUTH mechanism this client
+	// supports -- PLAIN, LOGIN, CRAM-MD5, XOAUTH2 -- carries the credential
+	// as base64, which is an encoding, not an encryption, so the lines
+	// exchanged during that window must be redacted rather than traced
+	// verbatim.
+	inAuth bool
+}
+
+func newTracedConn(conn net.Conn, logger Logger) net.Conn {
+	return &tracedConn{Conn: conn, logger: logger}
+}
+
+func (t *tracedConn) Read(p []byte) (int, error) {
+	n, err := t.Conn.Read(p)
+	if n > 0 {
+		t.rbuf = t.traceLines(ServerToClient, t.rbuf, p[:n])
+	}
+	return n, err
+}
+
+func (t *tracedConn) Write(p []byte) (int, error) {
+	n, err := t.Conn.Write(p)
+	if n > 0 {
+		t.wbuf = t.traceLines(ClientToServer, t.wbuf, p[:n])
+	}
+	return n, err
+}
+
+// traceLines appends chunk to buf, peels off and logs every complete CRLF
+// line, and returns the unconsumed remainder to be carried over to the next
+// call.
+func (t *tracedConn) traceLines(dir Direction, buf []byte, chunk []byte) []byte {
+	buf = append(buf, chunk...)
+	for {
+		idx := bytes.Index(buf, []byte("\r\n"))
+		if idx < 0 {
+			break
+		}
+		verb, payload, found := strings.Cut(string(buf[:idx]), " ")
+		if !found {
+			payload = ""
+		}
+		verb, payload = t.redact(dir, verb, payload)
+		t.logger.Log(dir, verb, payload)
+		buf = buf[idx+2:]
+	}
+	return buf
+}
+
+// redact masks the credential-bearing parts of an AUTH exchange, tracking
+// inAuth across calls since the secret itself usually arrives as a
+// follow-up continuation line rather than on the AUTH command itself.
+func (t *tracedConn) redact(dir Direction, verb, payload string) (string, string) {
+	if dir == ClientToServer && strings.EqualFold(verb, "AUTH") {
+		t.inAuth = true
+		mech, rest, hasRest := strings.Cut(payload, " ")
+		if hasRest && rest != "" {
+			return verb, mech + " [REDACTED]"
+		}
+		return verb, mech
+	}
+	if !t.inAuth {
+		return verb, payload
+	}
+	if dir == ClientToServer {
+		// The continuation line is a bare base64 blob with no space in it,
+		// so strings.Cut put the whole secret into verb and left payload
+		// empty -- redact verb too, not just payload.
+		return "[REDACTED]", ""
+	}
+	// Any server reply other than a 334 continuation -- success, failure,
+	// or a protocol error -- ends the exchange.
+	if verb != "334" {
+		t.inAuth = false
+	}
+	return verb, payload
+}
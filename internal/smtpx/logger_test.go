@@ -0,0 +1,72 @@
+package smtpx
+
+import "testing"
+
+type recordedLine struct {
+	dir     Direction
+	verb    string
+	payload string
+}
+
+func TestTracedConnRedactsAuthExchange(t *testing.T) {
+	var got []recordedLine
+	logger := LoggerFunc(func(dir Direction, verb, payload string) {
+		got = append(got, recordedLine{dir, verb, payload})
+	})
+	tc := &tracedConn{logger: logger}
+
+	// AUTH LOGIN has no inline response, so nothing to redact on this line.
+	tc.wbuf = tc.traceLines(ClientToServer, tc.wbuf, []byte("AUTH LOGIN\r\n"))
+	// Server prompts for the username; not itself secret.
+	tc.rbuf = tc.traceLines(ServerToClient, tc.rbuf, []byte("334 VXNlcm5hbWU6\r\n"))
+	// The base64 username/password that follow must be redacted.
+	tc.wbuf = tc.traceLines(ClientToServer, tc.wbuf, []byte("YWxpY2U=\r\n"))
+	tc.rbuf = tc.traceLines(ServerToClient, tc.rbuf, []byte("334 UGFzc3dvcmQ6\r\n"))
+	tc.wbuf = tc.traceLines(ClientToServer, tc.wbuf, []byte("c2VjcmV0\r\n"))
+	// Final reply ends the exchange; subsequent lines trace normally.
+	tc.rbuf = tc.traceLines(ServerToClient, tc.rbuf, []byte("235 Authentication successful\r\n"))
+	tc.wbuf = tc.traceLines(ClientToServer, tc.wbuf, []byte("MAIL FROM:<a@example.com>\r\n"))
+
+	want := []recordedLine{
+		{ClientToServer, "AUTH", "LOGIN"},
+		{ServerToClient, "334", "VXNlcm5hbWU6"},
+		{ClientToServer, "[REDACTED]", ""},
+		{ServerToClient, "334", "UGFzc3dvcmQ6"},
+		{ClientToServer, "[REDACTED]", ""},
+		{ServerToClient, "235", "Authentication successful"},
+		{ClientToServer, "MAIL", "FROM:<a@example.com>"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	for _, secret := range []string{"YWxpY2U=", "c2VjcmV0"} {
+		for _, l := range got {
+			if l.verb == secret || l.payload == secret {
+				t.Errorf("traced output leaked credential %q: %+v", secret, l)
+			}
+		}
+	}
+}
+
+func TestTracedConnRedactsInlineAuthPlain(t *testing.T) {
+	var got []recordedLine
+	logger := LoggerFunc(func(dir Direction, verb, payload string) {
+		got = append(got, recordedLine{dir, verb, payload})
+	})
+	tc := &tracedConn{logger: logger}
+
+	tc.wbuf = tc.traceLines(ClientToServer, tc.wbuf, []byte("AUTH PLAIN AGFsaWNlAHNlY3JldA==\r\n"))
+	tc.rbuf = tc.traceLines(ServerToClient, tc.rbuf, []byte("235 Authentication successful\r\n"))
+
+	if len(got) != 2 {
+		t.Fatalf("got %d lines, want 2: %+v", len(got), got)
+	}
+	if got[0].verb != "AUTH" || got[0].payload != "PLAIN [REDACTED]" {
+		t.Errorf("AUTH line = %+v, want payload %q", got[0], "PLAIN [REDACTED]")
+	}
+}
@@ -0,0 +1,137 @@
+package smtpx
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MailAndRcpts issues MAIL FROM followed by one RCPT TO per recipient,
+// optionally requesting delivery status notifications per dsn (nil omits
+// DSN parameters entirely). If the server has advertised PIPELINING, every
+// command is written to the wire before any response is read, turning what
+// would otherwise be len(to)+1 round trips -- the whole point of
+// distribution-list expansion, where to can run into the hundreds -- into
+// one round trip. If the server hasn't advertised PIPELINING, this falls
+// back to the ordinary one-command-per-round-trip behavior.
+//
+// The returned rcptErrs slice is always the same length as to: a nil entry
+// means that recipient was accepted, any other value is the rejection
+// error for that recipient. A non-nil mailErr means the transaction could
+// not be started -- the MAIL FROM was rejected, or a command could not be
+// validated or written -- and rcptErrs is nil in that case. On the
+// pipelined path the RCPT commands may already have been sent by then;
+// their responses are read and discarded.
+func (c *Client) MailAndRcpts(from string, to []string, dsn *DSNOptions) (rcptErrs []error, mailErr error) {
+	if err := validateLine(from); err != nil {
+		return nil, err
+	}
+	for _, addr := range to {
+		if err := validateLine(addr); err != nil {
+			return nil, err
+		}
+	}
+	if mailErr = c.hello(); mailErr != nil {
+		return nil, mailErr
+	}
+
+	mailFormat, mailArgs := c.mailCmd(from, dsn)
+
+	if ok, _ := c.Extension("PIPELINING"); !ok {
+		if _, _, mailErr = c.cmd(250, mailFormat, mailArgs...); mailErr != nil {
+			return nil, mailErr
+		}
+		rcptErrs = make([]error, len(to))
+		for i, addr := range to {
+			format, args := rcptCmd(addr, dsn)
+			_, _, rcptErrs[i] = c.cmd(25, format, args...)
+		}
+		return rcptErrs, nil
+	}
+
+	mailID, err := c.Text.Cmd(mailFormat, mailArgs...)
+	if err != nil {
+		return nil, err
+	}
+	rcptIDs := make([]uint, len(to))
+	for i, addr := range to {
+		format, args := rcptCmd(addr, dsn)
+		id, err := c.Text.Cmd(format, args...)
+		if err != nil {
+			return nil, err
+		}
+		rcptIDs[i] = id
+	}
+
+	c.Text.StartResponse(mailID)
+	_, _, mailErr = c.Text.ReadResponse(250)
+	c.Text.EndResponse(mailID)
+	if mailErr != nil {
+		// The server already has the RCPT lines buffered and will
+		// answer each of them even though the transaction is dead;
+		// drain those responses so the connection stays in sync for
+		// whatever comes next.
+		for _, id := range rcptIDs {
+			c.Text.StartResponse(id)
+			c.Text.ReadResponse(0)
+			c.Text.EndResponse(id)
+		}
+		return nil, mailErr
+	}
+
+	rcptErrs = make([]error, len(to))
+	for i, id := range rcptIDs {
+		c.Text.StartResponse(id)
+		_, _, rcptErrs[i] = c.Text.ReadResponse(25)
+		c.Text.EndResponse(id)
+	}
+	return rcptErrs, nil
+}
+
+// mailCmd builds the MAIL FROM command as a Cmd-style format string plus
+// its arguments, so that addresses or DSN parameters containing a literal
+// '%' can never be misread as a format verb.
+func (c *Client) mailCmd(from string, dsn *DSNOptions) (format string, args []any) {
+	format, args = "MAIL FROM:<%s>", []any{from}
+	if _, ok := c.ext["8BITMIME"]; ok {
+		format += " BODY=8BITMIME"
+	}
+	if dsn != nil {
+		if dsn.Ret != "" {
+			format += " RET=%s"
+			args = append(args, dsn.Ret)
+		}
+		if dsn.EnvID != "" {
+			format += " ENVID=%s"
+			args = append(args, dsn.EnvID)
+		}
+	}
+	return format, args
+}
+
+// rcptCmd builds the RCPT TO command the same way mailCmd does.
+func rcptCmd(addr string, dsn *DSNOptions) (format string, args []any) {
+	format, args = "RCPT TO:<%s>", []any{addr}
+	if dsn != nil && len(dsn.Notify) > 0 {
+		format += " NOTIFY=%s ORCPT=rfc822;%s"
+		args = append(args, strings.Join(dsn.Notify, ","), xtextEncode(addr))
+	}
+	return format, args
+}
+
+// xtextEncode applies the "xtext" encoding RFC 3461 section 4 requires for
+// the address carried in ORCPT=: every byte outside the printable,
+// non-"+"/"=" ASCII range is replaced with "+XX", its hex value, so that an
+// address containing a literal '+', '=', or control character can't be
+// misread as part of the xtext syntax itself.
+func xtextEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,199 @@
+package smtpx
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestXtextEncode(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"bob@example.com", "bob@example.com"},
+		{"a+b@example.com", "a+2Bb@example.com"},
+		{"a=b@example.com", "a+3Db@example.com"},
+		{"tab\tend@example.com", "tab+09end@example.com"},
+	}
+	for _, tc := range cases {
+		if got := xtextEncode(tc.in); got != tc.want {
+			t.Errorf("xtextEncode(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestMailCmd(t *testing.T) {
+	cases := []struct {
+		name       string
+		ext        map[string]string
+		dsn        *DSNOptions
+		wantFormat string
+		wantArgs   []any
+	}{
+		{
+			name:       "no extensions, no dsn",
+			wantFormat: "MAIL FROM:<%s>",
+			wantArgs:   []any{"alice@example.com"},
+		},
+		{
+			name:       "8BITMIME advertised",
+			ext:        map[string]string{"8BITMIME": ""},
+			wantFormat: "MAIL FROM:<%s> BODY=8BITMIME",
+			wantArgs:   []any{"alice@example.com"},
+		},
+		{
+			name:       "RET and ENVID",
+			dsn:        &DSNOptions{Ret: "FULL", EnvID: "abc123"},
+			wantFormat: "MAIL FROM:<%s> RET=%s ENVID=%s",
+			wantArgs:   []any{"alice@example.com", "FULL", "abc123"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Client{ext: tc.ext}
+			format, args := c.mailCmd("alice@example.com", tc.dsn)
+			if format != tc.wantFormat {
+				t.Errorf("format = %q, want %q", format, tc.wantFormat)
+			}
+			if len(args) != len(tc.wantArgs) {
+				t.Fatalf("args = %v, want %v", args, tc.wantArgs)
+			}
+			for i := range args {
+				if args[i] != tc.wantArgs[i] {
+					t.Errorf("args[%d] = %v, want %v", i, args[i], tc.wantArgs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRcptCmd(t *testing.T) {
+	cases := []struct {
+		name       string
+		dsn        *DSNOptions
+		wantFormat string
+		wantArgs   []any
+	}{
+		{
+			name:       "no dsn",
+			wantFormat: "RCPT TO:<%s>",
+			wantArgs:   []any{"bob@example.com"},
+		},
+		{
+			name:       "NOTIFY and xtext-encoded ORCPT",
+			dsn:        &DSNOptions{Notify: []string{"SUCCESS", "FAILURE"}},
+			wantFormat: "RCPT TO:<%s> NOTIFY=%s ORCPT=rfc822;%s",
+			wantArgs:   []any{"bob@example.com", "SUCCESS,FAILURE", "bob@example.com"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			format, args := rcptCmd("bob@example.com", tc.dsn)
+			if format != tc.wantFormat {
+				t.Errorf("format = %q, want %q", format, tc.wantFormat)
+			}
+			if len(args) != len(tc.wantArgs) {
+				t.Fatalf("args = %v, want %v", args, tc.wantArgs)
+			}
+			for i := range args {
+				if args[i] != tc.wantArgs[i] {
+					t.Errorf("args[%d] = %v, want %v", i, args[i], tc.wantArgs[i])
+				}
+			}
+		})
+	}
+}
+
+// fakeServer listens on the loopback interface and, for the first
+// connection it accepts, speaks just enough SMTP to drive MailAndRcpts: it
+// sends greeting, then one scripted response per line it reads, in order,
+// ignoring the line's actual content (the wire format itself is covered by
+// TestMailCmd/TestRcptCmd above). A real socket, rather than net.Pipe, is
+// used because the pipelining path under test writes MAIL and every RCPT
+// before reading any response -- over net.Pipe's unbuffered, fully
+// synchronous Read/Write pairing, this server's reply to MAIL would block
+// until the client reads it while the client is itself blocked writing the
+// next RCPT, a deadlock; a loopback TCP connection's kernel buffers absorb
+// both sides' writes.
+func fakeServer(t *testing.T, greeting string, responses []string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		io.WriteString(conn, greeting)
+		for _, resp := range responses {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			io.WriteString(conn, resp)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestMailAndRcptsPipelined(t *testing.T) {
+	addr := fakeServer(t, "220 mail.example.com ESMTP ready\r\n", []string{
+		"250-mail.example.com\r\n250-PIPELINING\r\n250 DSN\r\n", // EHLO
+		"250 OK\r\n",           // MAIL FROM
+		"250 OK\r\n",           // RCPT TO bob
+		"550 no such user\r\n", // RCPT TO eve
+	})
+
+	c, err := Dial(addr, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	rcptErrs, mailErr := c.MailAndRcpts("alice@example.com", []string{"bob@example.com", "eve@example.com"}, nil)
+	if mailErr != nil {
+		t.Fatalf("mailErr = %v, want nil", mailErr)
+	}
+	if len(rcptErrs) != 2 {
+		t.Fatalf("rcptErrs = %v, want len 2", rcptErrs)
+	}
+	if rcptErrs[0] != nil {
+		t.Errorf("rcptErrs[0] = %v, want nil", rcptErrs[0])
+	}
+	if rcptErrs[1] == nil {
+		t.Errorf("rcptErrs[1] = nil, want a rejection error")
+	}
+}
+
+func TestMailAndRcptsPipelinedMailRejectedDrainsRcptResponses(t *testing.T) {
+	addr := fakeServer(t, "220 mail.example.com ESMTP ready\r\n", []string{
+		"250-mail.example.com\r\n250-PIPELINING\r\n250 DSN\r\n", // EHLO
+		"451 temporary failure\r\n",                             // MAIL FROM rejected
+		"250 OK\r\n",                                            // RCPT TO bob -- still answered
+		"250 OK\r\n",                                            // RCPT TO eve -- still answered
+		"250 OK\r\n",                                            // RSET, after MailAndRcpts returns
+	})
+
+	c, err := Dial(addr, nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer c.Close()
+
+	rcptErrs, mailErr := c.MailAndRcpts("alice@example.com", []string{"bob@example.com", "eve@example.com"}, nil)
+	if mailErr == nil {
+		t.Fatal("mailErr = nil, want an error")
+	}
+	if rcptErrs != nil {
+		t.Errorf("rcptErrs = %v, want nil", rcptErrs)
+	}
+
+	// If the buffered RCPT responses weren't drained, this RSET would read
+	// one of them instead of its own reply and fail.
+	if err := c.Reset(); err != nil {
+		t.Errorf("Reset after a rejected pipelined MAIL: %v, want nil (connection should still be in sync)", err)
+	}
+}
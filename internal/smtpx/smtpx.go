@@ -0,0 +1,349 @@
+// Package smtpx is a fork of the standard library's net/smtp, kept close to
+// the upstream implementation but with changes that net/smtp's "frozen,
+// not accepting new features" policy rules out:
+//
+//   - a pluggable Logger that observes every line crossing the wire, tagged
+//     with its direction and verb, so callers can get full protocol traces;
+//   - an EHLO failure path that surfaces the server's actual error instead
+//     of silently retrying HELO and discarding the root cause;
+//   - MailAndRcpts, which pipelines MAIL FROM and the RCPT TOs when the
+//     server advertises PIPELINING and can attach RFC 3461 DSN parameters.
+//
+// Forking rather than wrapping net/smtp was necessary because the conn and
+// the extension map are unexported; there is no seam to
+// hook a logger or to change the HELO fallback behavior from outside the
+// package. The API intentionally mirrors net/smtp so callers familiar with
+// the stdlib package feel at home.
+package smtpx
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// A Client represents a client connection to an SMTP server.
+type Client struct {
+	// Text is the textproto.Conn used by the Client. It is exported to allow
+	// callers to add extensions.
+	Text *textproto.Conn
+	// keep a reference to the connection so it can be used to create a TLS
+	// connection later
+	conn net.Conn
+	// whether the Client is using TLS
+	tls        bool
+	serverName string
+	// map of supported extensions
+	ext map[string]string
+	// supported auth mechanisms
+	auth       []string
+	localName  string // the name to use in HELO/EHLO
+	didHello   bool   // whether we've said HELO/EHLO
+	helloError error  // the error from the hello
+
+	logger Logger // nil unless protocol tracing was requested
+}
+
+// Dial returns a new Client connected to an SMTP server at addr. The addr
+// must include a port, as in "mail.example.com:smtp". If logger is non-nil,
+// every line read from or written to the server is reported to it.
+func Dial(addr string, logger Logger) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	return NewClient(conn, host, logger)
+}
+
+// NewClient returns a new Client using an existing connection and host as a
+// server name to be used when authenticating. If logger is non-nil, every
+// line read from or written to conn is reported to it.
+func NewClient(conn net.Conn, host string, logger Logger) (*Client, error) {
+	_, isTLS := conn.(*tls.Conn)
+	if logger != nil {
+		conn = newTracedConn(conn, logger)
+	}
+	text := textproto.NewConn(conn)
+	_, _, err := text.ReadResponse(220)
+	if err != nil {
+		text.Close()
+		return nil, err
+	}
+	c := &Client{Text: text, conn: conn, serverName: host, localName: "localhost", logger: logger}
+	c.tls = isTLS
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.Text.Close()
+}
+
+// hello runs a hello exchange if needed.
+func (c *Client) hello() error {
+	if !c.didHello {
+		c.didHello = true
+		if err := c.ehlo(); err != nil {
+			// Only fall back to HELO when the server told us it doesn't
+			// understand EHLO (500); any other error -- a timeout, a 4xx
+			// or 5xx rejection, a malformed greeting -- is the real root
+			// cause and must be reported as-is, not masked by whatever
+			// HELO happens to return.
+			var perr *textproto.Error
+			if errors.As(err, &perr) && perr.Code == 500 {
+				c.helloError = c.helo()
+			} else {
+				c.helloError = err
+			}
+		}
+	}
+	return c.helloError
+}
+
+// cmd is a convenience function that sends a command and returns the response
+func (c *Client) cmd(expectCode int, format string, args ...any) (int, string, error) {
+	id, err := c.Text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	code, msg, err := c.Text.ReadResponse(expectCode)
+	return code, msg, err
+}
+
+// helo sends the HELO greeting to the server. It should be used only when
+// the server does not support EHLO.
+func (c *Client) helo() error {
+	c.ext = nil
+	_, _, err := c.cmd(250, "HELO %s", c.localName)
+	return err
+}
+
+// ehlo sends the EHLO (extended hello) greeting to the server. It should be
+// the preferred greeting for servers that support it.
+func (c *Client) ehlo() error {
+	_, msg, err := c.cmd(250, "EHLO %s", c.localName)
+	if err != nil {
+		return err
+	}
+	ext := make(map[string]string)
+	extList := strings.Split(msg, "\n")
+	if len(extList) > 1 {
+		extList = extList[1:]
+		for _, line := range extList {
+			k, v, _ := strings.Cut(line, " ")
+			ext[k] = v
+		}
+	}
+	if mechs, ok := ext["AUTH"]; ok {
+		c.auth = strings.Split(mechs, " ")
+	}
+	c.ext = ext
+	return err
+}
+
+// Hello sends a HELO or EHLO to the server as the given host name. Calling
+// this method is only necessary if the client needs control over the host
+// name used. If Hello is called, it must be called before any other method.
+func (c *Client) Hello(localName string) error {
+	if err := validateLine(localName); err != nil {
+		return err
+	}
+	if c.didHello {
+		return errors.New("smtpx: Hello called after other methods")
+	}
+	c.localName = localName
+	return c.hello()
+}
+
+// StartTLS sends the STARTTLS command and encrypts all further
+// communication. Only servers that advertise the STARTTLS extension support
+// this function.
+func (c *Client) StartTLS(config *tls.Config) error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd(220, "STARTTLS"); err != nil {
+		return err
+	}
+	// Wrap the raw connection, not the tracer around it: the tracer must
+	// see the plaintext lines, never the TLS records.
+	raw := c.conn
+	if t, ok := raw.(*tracedConn); ok {
+		raw = t.Conn
+	}
+	c.conn = tls.Client(raw, config)
+	if c.logger != nil {
+		c.conn = newTracedConn(c.conn, c.logger)
+	}
+	c.Text = textproto.NewConn(c.conn)
+	c.tls = true
+	return c.ehlo()
+}
+
+// TLSConnectionState returns the client's TLS connection state. The return
+// values are their zero values if StartTLS did not succeed.
+func (c *Client) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	conn := c.conn
+	if t, ok := conn.(*tracedConn); ok {
+		conn = t.Conn
+	}
+	tc, ok := conn.(*tls.Conn)
+	if !ok {
+		return
+	}
+	return tc.ConnectionState(), true
+}
+
+// Auth authenticates a client using the provided authentication mechanism. A
+// failed authentication closes the connection. Only servers that advertise
+// the AUTH extension support this function.
+func (c *Client) Auth(a Auth) error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	encoding := base64.StdEncoding
+	mech, resp, err := a.Start(&ServerInfo{c.serverName, c.tls, c.auth})
+	if err != nil {
+		c.Quit()
+		return err
+	}
+	resp64 := make([]byte, encoding.EncodedLen(len(resp)))
+	encoding.Encode(resp64, resp)
+	code, msg64, err := c.cmd(0, strings.TrimSpace(fmt.Sprintf("AUTH %s %s", mech, resp64)))
+	for err == nil {
+		var msg []byte
+		switch code {
+		case 334:
+			msg, err = encoding.DecodeString(msg64)
+		case 235:
+			// the last message isn't base64 because it isn't a challenge
+			msg = []byte(msg64)
+		default:
+			err = &textproto.Error{Code: code, Msg: msg64}
+		}
+		if err == nil {
+			resp, err = a.Next(msg, code == 334)
+		}
+		if err != nil {
+			// abort the AUTH
+			c.cmd(501, "*")
+			c.Quit()
+			break
+		}
+		if resp == nil {
+			break
+		}
+		resp64 = make([]byte, encoding.EncodedLen(len(resp)))
+		encoding.Encode(resp64, resp)
+		code, msg64, err = c.cmd(0, string(resp64))
+	}
+	return err
+}
+
+// Mail issues a MAIL command to the server using the provided email address.
+// If the server supports the 8BITMIME extension, Mail adds the BODY=8BITMIME
+// parameter. This initiates a mail transaction and is followed by one or
+// more Rcpt calls.
+func (c *Client) Mail(from string) error {
+	if err := validateLine(from); err != nil {
+		return err
+	}
+	if err := c.hello(); err != nil {
+		return err
+	}
+	cmdStr := "MAIL FROM:<%s>"
+	if c.ext != nil {
+		if _, ok := c.ext["8BITMIME"]; ok {
+			cmdStr += " BODY=8BITMIME"
+		}
+	}
+	_, _, err := c.cmd(250, cmdStr, from)
+	return err
+}
+
+// Rcpt issues a RCPT command to the server using the provided email
+// address. A call to Rcpt must be preceded by a call to Mail and may be
+// followed by a Data call or another Rcpt call.
+func (c *Client) Rcpt(to string) error {
+	if err := validateLine(to); err != nil {
+		return err
+	}
+	_, _, err := c.cmd(25, "RCPT TO:<%s>", to)
+	return err
+}
+
+type dataCloser struct {
+	c *Client
+	io.WriteCloser
+}
+
+func (d *dataCloser) Close() error {
+	d.WriteCloser.Close()
+	_, _, err := d.c.Text.ReadResponse(250)
+	return err
+}
+
+// Data issues a DATA command to the server and returns a writer that can be
+// used to write the mail headers and body. The caller should close the
+// writer before calling any more methods on c. A call to Data must be
+// preceded by one or more calls to Rcpt.
+func (c *Client) Data() (io.WriteCloser, error) {
+	_, _, err := c.cmd(354, "DATA")
+	if err != nil {
+		return nil, err
+	}
+	return &dataCloser{c, c.Text.DotWriter()}, nil
+}
+
+// Extension reports whether an extension is supported by the server. The
+// extension name is case-insensitive. If the extension is supported,
+// Extension also returns a string that contains any parameters the server
+// specifies for the extension.
+func (c *Client) Extension(ext string) (bool, string) {
+	if err := c.hello(); err != nil {
+		return false, ""
+	}
+	if c.ext == nil {
+		return false, ""
+	}
+	ext = strings.ToUpper(ext)
+	param, ok := c.ext[ext]
+	return ok, param
+}
+
+// Reset sends the RSET command to the server, aborting the current mail
+// transaction.
+func (c *Client) Reset() error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	_, _, err := c.cmd(250, "RSET")
+	return err
+}
+
+// Quit sends the QUIT command and closes the connection to the server.
+func (c *Client) Quit() error {
+	if err := c.hello(); err != nil {
+		return err
+	}
+	if _, _, err := c.cmd(221, "QUIT"); err != nil {
+		return err
+	}
+	return c.Text.Close()
+}
+
+// validateLine checks to see if a line has CR or LF as per RFC 5321.
+func validateLine(line string) error {
+	if strings.ContainsAny(line, "\n\r") {
+		return errors.New("smtpx: a line must not contain CR or LF")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// promptPassword writes prompt to stdout and reads one line of secret
+// input from /dev/tty, with echo disabled. It's factored out of main() so
+// any future caller that needs to ask for a secret interactively -- an
+// XOAUTH2 refresh token, say -- can reuse it instead of re-deriving the
+// /dev/tty dance.
+func promptPassword(prompt string) (string, error) {
+	fmt.Print(prompt)
+
+	termf, err := os.Open("/dev/tty")
+	if err != nil {
+		return "", err
+	}
+	defer termf.Close()
+
+	if fd := int(termf.Fd()); term.IsTerminal(fd) {
+		secret, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		return string(secret), nil
+	}
+
+	// Not a terminal -- most likely /dev/tty was redirected by a test
+	// harness.  Fall back to a plain line read; there's no echo to
+	// suppress when the input isn't a terminal anyway.
+	reader := bufio.NewReader(termf)
+	line, _, err := reader.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
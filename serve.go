@@ -0,0 +1,90 @@
+/*
+serve.go -- the -L submission server mode.
+
+gosmtp -L addr:port --maildir dir  (or --pipe cmd) turns the binary around:
+instead of submitting messages out, it listens for them, on the small RFC
+5321 server implemented in the server package. This file just wires the
+command line flags to that package; the protocol machinery lives there.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+
+	"github.com/alameth/hello-smtp/server"
+)
+
+// runServer returns an error on any failure to start or run the server, so
+// main can exit non-zero instead of reporting success to whatever supervises
+// this process; the caller is responsible for the corresponding os.Exit.
+func runServer(addr string) error {
+	if addr == "" {
+		errlog.Println(shortUsage)
+		return fmt.Errorf("missing listen address (-L addr:port)")
+	}
+
+	var backend server.Backend
+	var err error
+	switch {
+	case *optMaildir != "" && *optPipeCmd != "":
+		return fmt.Errorf("the --maildir and --pipe options cannot be used together")
+	case *optMaildir != "":
+		backend, err = server.NewMaildirBackend(*optMaildir)
+	case *optPipeCmd != "":
+		backend = server.NewPipeBackend(*optPipeCmd)
+	default:
+		return fmt.Errorf("server mode requires either --maildir dir or --pipe cmd")
+	}
+	if err != nil {
+		return err
+	}
+
+	heloname := *optHeloName
+	if heloname == "" {
+		heloname = "gosmtp.example.com"
+	}
+	srv := server.New(heloname, backend)
+
+	if *optAllowList != "" {
+		al, err := server.LoadAllowList(*optAllowList)
+		if err != nil {
+			return err
+		}
+		srv.AllowList = al
+	}
+
+	if *optServerCert != "" || *optServerKey != "" {
+		cert, err := tls.LoadX509KeyPair(*optServerCert, *optServerKey)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	if *optUser != "" {
+		// Server-mode AUTH reuses -U/-P as the single accepted
+		// credential pair; there's no user database here, just a
+		// gate in front of the relay.
+		srv.Auth = server.AuthConfig{
+			Enabled:    true,
+			RequireTLS: true,
+			Userpass: func(user, password string) bool {
+				return user == *optUser && password == *optPassword
+			},
+		}
+	}
+
+	maxSize, err := strconv.ParseInt(*optMaxSize, 10, 64)
+	if err != nil {
+		return fmt.Errorf("malformed --max-size value %q: %w", *optMaxSize, err)
+	}
+	srv.MaxMessageBytes = maxSize
+
+	if *optVerbose {
+		errlog.Println("Listening on", addr)
+	}
+	return srv.ListenAndServe(addr)
+}
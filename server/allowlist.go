@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// AllowList restricts which envelope recipients a connection will actually
+// deliver to the Backend. It implements the Marinetti-style inbound-only
+// relay behavior: addresses not on the list still get a 250 OK at RCPT TO
+// time -- so a probing sender can't tell "delivered" from "blackholed" --
+// but Session.Rcpt is never called for them, and the backend never learns
+// they existed.
+//
+// A nil *AllowList (the default, no --allow flag given) accepts everyone.
+type AllowList struct {
+	addrs map[string]bool
+}
+
+// LoadAllowList reads one address per line from path. Blank lines and
+// lines starting with "#" are ignored. Matching is case-insensitive, per
+// the local-part conventions most real mail systems use in practice even
+// though RFC 5321 technically leaves local-part case significance to the
+// receiving system.
+func LoadAllowList(path string) (*AllowList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	al := &AllowList{addrs: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		al.addrs[strings.ToLower(line)] = true
+	}
+	return al, scanner.Err()
+}
+
+// Allowed reports whether addr may be handed to the backend.
+func (al *AllowList) Allowed(addr string) bool {
+	if al == nil {
+		return true
+	}
+	return al.addrs[strings.ToLower(addr)]
+}
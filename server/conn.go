@@ -0,0 +1,396 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// conn is the per-connection state machine. One is created per accepted
+// connection and runs entirely on the goroutine ListenAndServe spawned for
+// it; there is no shared mutable state between connections.
+type conn struct {
+	srv  *Server
+	nc   net.Conn
+	text *textproto.Conn
+
+	state   ConnectionState
+	session Session
+
+	helloSeen bool
+	mailFrom  string
+	mailOpts  MailOptions
+	rcpts     []string
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	c := &conn{srv: s, nc: &lineLimitConn{Conn: nc, max: s.MaxLineLength}}
+	c.text = textproto.NewConn(c.nc)
+	c.state.RemoteAddr = nc.RemoteAddr()
+	defer c.text.Close()
+	defer func() {
+		if c.session != nil {
+			c.session.Logout()
+		}
+	}()
+
+	c.writeResponse(220, s.Domain+" ESMTP ready")
+	for {
+		line, err := c.text.ReadLine()
+		if err != nil {
+			return
+		}
+		if !c.handle(line) {
+			return
+		}
+	}
+}
+
+// handle processes one command line and reports whether the connection
+// should stay open. Each response is written as soon as its command is
+// handled, and commands a client sent ahead are held in textproto's
+// buffered reader until the read loop gets to them, so a client that
+// pipelines MAIL/RCPT/RCPT/... per the advertised PIPELINING extension is
+// handled with no special-casing here at all.
+func (c *conn) handle(line string) bool {
+	verb, arg := splitVerb(line)
+	switch strings.ToUpper(verb) {
+	case "EHLO", "HELO":
+		c.handleHello(verb, arg)
+	case "STARTTLS":
+		c.handleStartTLS()
+	case "AUTH":
+		c.handleAuth(arg)
+	case "MAIL":
+		c.handleMail(arg)
+	case "RCPT":
+		c.handleRcpt(arg)
+	case "DATA":
+		c.handleData()
+	case "RSET":
+		c.reset()
+		c.writeResponse(250, "OK")
+	case "NOOP":
+		c.writeResponse(250, "OK")
+	case "QUIT":
+		c.writeResponse(221, "Bye")
+		return false
+	default:
+		c.writeResponse(500, "Command not recognized")
+	}
+	return true
+}
+
+func (c *conn) handleHello(verb, arg string) {
+	if arg == "" {
+		c.writeResponse(501, "Syntax: "+strings.ToUpper(verb)+" hostname")
+		return
+	}
+	c.state.HeloName = arg
+	c.reset()
+	c.helloSeen = true
+
+	if strings.ToUpper(verb) == "HELO" {
+		c.writeResponse(250, c.srv.Domain)
+		return
+	}
+
+	lines := []string{c.srv.Domain, "PIPELINING", "8BITMIME"}
+	if c.srv.MaxMessageBytes > 0 {
+		lines = append(lines, fmt.Sprintf("SIZE %d", c.srv.MaxMessageBytes))
+	}
+	if c.srv.TLSConfig != nil && !c.state.TLS {
+		lines = append(lines, "STARTTLS")
+	}
+	if c.srv.Auth.Enabled && (c.state.TLS || !c.srv.Auth.RequireTLS) {
+		lines = append(lines, "AUTH PLAIN LOGIN")
+	}
+	c.writeMultiResponse(250, lines)
+}
+
+func (c *conn) handleStartTLS() {
+	if c.srv.TLSConfig == nil {
+		c.writeResponse(502, "TLS not supported")
+		return
+	}
+	if c.state.TLS {
+		c.writeResponse(502, "TLS already active")
+		return
+	}
+	c.writeResponse(220, "Go ahead")
+
+	tlsConn := tls.Server(c.nc, c.srv.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		// The connection is unusable now; let the read loop's next
+		// ReadLine fail and close it.
+		return
+	}
+	c.nc = &lineLimitConn{Conn: tlsConn, max: c.srv.MaxLineLength}
+	c.text = textproto.NewConn(c.nc)
+	c.state.TLS = true
+
+	// RFC 3207 requires discarding any prior EHLO state.
+	c.helloSeen = false
+	c.reset()
+}
+
+func (c *conn) handleAuth(arg string) {
+	if !c.srv.Auth.Enabled {
+		c.writeResponse(502, "AUTH not supported")
+		return
+	}
+	if c.srv.Auth.RequireTLS && !c.state.TLS {
+		c.writeResponse(538, "Encryption required for requested authentication mechanism")
+		return
+	}
+	mech, rest := splitVerb(arg)
+	switch strings.ToUpper(mech) {
+	case "PLAIN":
+		c.authPlain(rest)
+	case "LOGIN":
+		c.authLogin(rest)
+	default:
+		c.writeResponse(504, "Unrecognized authentication mechanism")
+	}
+}
+
+func (c *conn) authPlain(initial string) {
+	if initial == "" {
+		initial = c.readContinuation("")
+		if initial == "" {
+			return
+		}
+	}
+	raw, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		c.writeResponse(501, "Malformed AUTH PLAIN response")
+		return
+	}
+	parts := strings.SplitN(string(raw), "\x00", 3)
+	if len(parts) != 3 {
+		c.writeResponse(501, "Malformed AUTH PLAIN response")
+		return
+	}
+	c.finishAuth(parts[1], parts[2])
+}
+
+func (c *conn) authLogin(initial string) {
+	user := initial
+	if user == "" {
+		user = c.readContinuation(base64.StdEncoding.EncodeToString([]byte("Username:")))
+	}
+	if raw, err := base64.StdEncoding.DecodeString(user); err == nil {
+		user = string(raw)
+	}
+	passB64 := c.readContinuation(base64.StdEncoding.EncodeToString([]byte("Password:")))
+	pass, err := base64.StdEncoding.DecodeString(passB64)
+	if err != nil {
+		c.writeResponse(501, "Malformed AUTH LOGIN response")
+		return
+	}
+	c.finishAuth(user, string(pass))
+}
+
+// readContinuation sends a 334 continuation with the given base64 payload
+// (already encoded by the caller) and returns the client's next line.
+func (c *conn) readContinuation(b64 string) string {
+	c.text.PrintfLine("334 %s", b64)
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return ""
+	}
+	return line
+}
+
+func (c *conn) finishAuth(user, pass string) {
+	if c.srv.Auth.Userpass == nil || !c.srv.Auth.Userpass(user, pass) {
+		c.writeResponse(535, "Authentication credentials invalid")
+		return
+	}
+	c.state.AuthUser = user
+	c.writeResponse(235, "Authentication successful")
+}
+
+func (c *conn) handleMail(arg string) {
+	if !c.helloSeen {
+		c.writeResponse(503, "Send HELO/EHLO first")
+		return
+	}
+	if c.mailFrom != "" {
+		c.writeResponse(503, "Nested MAIL command")
+		return
+	}
+	addr, params, err := parseMailRcptArg("FROM", arg)
+	if err != nil {
+		c.writeResponse(501, err.Error())
+		return
+	}
+
+	opts := MailOptions{Body: "7BIT"}
+	for k, v := range params {
+		switch k {
+		case "SIZE":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				c.writeResponse(501, "Malformed SIZE parameter")
+				return
+			}
+			if c.srv.MaxMessageBytes > 0 && n > c.srv.MaxMessageBytes {
+				c.writeResponse(552, "Message exceeds fixed maximum message size")
+				return
+			}
+			opts.Size = n
+		case "BODY":
+			opts.Body = strings.ToUpper(v)
+		}
+	}
+
+	session, err := c.srv.Backend.NewSession(&c.state)
+	if err != nil {
+		c.writeResponse(451, err.Error())
+		return
+	}
+	if err := session.Mail(addr, opts); err != nil {
+		c.writeResponse(451, err.Error())
+		return
+	}
+	c.session = session
+	c.mailFrom = addr
+	c.mailOpts = opts
+	c.writeResponse(250, "OK")
+}
+
+func (c *conn) handleRcpt(arg string) {
+	if c.mailFrom == "" {
+		c.writeResponse(503, "Need MAIL before RCPT")
+		return
+	}
+	addr, _, err := parseMailRcptArg("TO", arg)
+	if err != nil {
+		c.writeResponse(501, err.Error())
+		return
+	}
+	if c.srv.AllowList.Allowed(addr) {
+		if err := c.session.Rcpt(addr); err != nil {
+			c.writeResponse(550, err.Error())
+			return
+		}
+	}
+	// Addresses the allow-list rejects are silently dropped here and
+	// never reach the backend -- see AllowList's doc comment.
+	c.rcpts = append(c.rcpts, addr)
+	c.writeResponse(250, "OK")
+}
+
+func (c *conn) handleData() {
+	if c.mailFrom == "" || len(c.rcpts) == 0 {
+		c.writeResponse(503, "Need MAIL/RCPT before DATA")
+		return
+	}
+	c.writeResponse(354, "Go ahead")
+
+	dr := c.text.DotReader()
+	var r io.Reader = dr
+	if c.srv.MaxMessageBytes > 0 {
+		r = &maxBytesReader{r: dr, n: c.srv.MaxMessageBytes + 1}
+	}
+	err := c.session.Data(r)
+	// Drain anything the backend didn't read so the dot-terminator is
+	// always consumed, keeping the connection in sync even if the
+	// backend returned early on an error.
+	io.Copy(io.Discard, dr)
+
+	switch {
+	case errors.Is(err, errMessageTooLarge):
+		c.writeResponse(552, "Message exceeds fixed maximum message size")
+	case err != nil:
+		c.writeResponse(554, err.Error())
+	default:
+		c.writeResponse(250, "OK: queued")
+	}
+	c.reset()
+}
+
+// errMessageTooLarge is returned by maxBytesReader once a message runs past
+// the server's configured limit.
+var errMessageTooLarge = errors.New("server: message exceeds maximum size")
+
+// maxBytesReader wraps an io.Reader and fails with errMessageTooLarge,
+// rather than a silent EOF, once more than n bytes have been read through
+// it. A silent truncation would let a Session.Data that reads to EOF (as
+// both MaildirBackend and PipeBackend do) treat an oversize message as a
+// successful, if short, delivery and commit it before handleData ever gets
+// a chance to reject it -- failing the read itself stops that short.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n <= 0 {
+		return 0, errMessageTooLarge
+	}
+	if int64(len(p)) > m.n {
+		p = p[:m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	return n, err
+}
+
+func (c *conn) reset() {
+	if c.session != nil {
+		c.session.Reset()
+	}
+	c.session = nil
+	c.mailFrom = ""
+	c.mailOpts = MailOptions{}
+	c.rcpts = nil
+}
+
+func (c *conn) writeResponse(code int, msg string) {
+	c.text.PrintfLine("%d %s", code, msg)
+}
+
+func (c *conn) writeMultiResponse(code int, lines []string) {
+	for i, l := range lines {
+		sep := byte('-')
+		if i == len(lines)-1 {
+			sep = ' '
+		}
+		c.text.PrintfLine("%d%c%s", code, sep, l)
+	}
+}
+
+// splitVerb splits a command line into its verb and the (trimmed)
+// remainder.
+func splitVerb(line string) (verb, arg string) {
+	verb, arg, _ = strings.Cut(strings.TrimSpace(line), " ")
+	return verb, strings.TrimSpace(arg)
+}
+
+// parseMailRcptArg parses the "FROM:<addr> PARAM=value ..." or
+// "TO:<addr> ..." argument of a MAIL or RCPT command.
+func parseMailRcptArg(kind, arg string) (addr string, params map[string]string, err error) {
+	prefix := kind + ":"
+	if !strings.HasPrefix(strings.ToUpper(arg), prefix) {
+		return "", nil, fmt.Errorf("Syntax: %s:<address> [ params... ]", kind)
+	}
+	fields := strings.Fields(arg[len(prefix):])
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("Syntax: %s:<address>", kind)
+	}
+	addr = strings.Trim(fields[0], "<>")
+	params = make(map[string]string)
+	for _, f := range fields[1:] {
+		k, v, _ := strings.Cut(f, "=")
+		params[strings.ToUpper(k)] = v
+	}
+	return addr, params, nil
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// recordingSession is a Backend/Session pair that records whatever it's
+// handed, for assertions, without doing any real delivery.
+type recordingSession struct {
+	from string
+	opts MailOptions
+	rcpt []string
+	data []byte
+}
+
+func (s *recordingSession) Mail(from string, opts MailOptions) error {
+	s.from, s.opts = from, opts
+	return nil
+}
+
+func (s *recordingSession) Rcpt(to string) error {
+	s.rcpt = append(s.rcpt, to)
+	return nil
+}
+
+// Data mirrors MaildirBackend's own pattern: read the whole message first,
+// and only record (i.e. "commit") it once that succeeds, so tests can tell
+// an oversize message was rejected before delivery rather than after.
+func (s *recordingSession) Data(r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.data = b
+	return nil
+}
+
+func (s *recordingSession) Reset()        {}
+func (s *recordingSession) Logout() error { return nil }
+
+type recordingBackend struct {
+	sessions []*recordingSession
+}
+
+func (b *recordingBackend) NewSession(state *ConnectionState) (Session, error) {
+	s := &recordingSession{}
+	b.sessions = append(b.sessions, s)
+	return s, nil
+}
+
+// testConn wires up a Server against one end of an in-memory pipe and
+// returns a textproto.Conn on the other end to drive it like a client.
+func testConn(t *testing.T, srv *Server) *textproto.Conn {
+	t.Helper()
+	client, serverSide := net.Pipe()
+	go srv.serveConn(serverSide)
+	t.Cleanup(func() { client.Close() })
+
+	tc := textproto.NewConn(client)
+	if _, _, err := tc.ReadResponse(220); err != nil {
+		t.Fatalf("greeting: %v", err)
+	}
+	return tc
+}
+
+func cmd(t *testing.T, tc *textproto.Conn, wantCode int, line string) string {
+	t.Helper()
+	if err := tc.PrintfLine("%s", line); err != nil {
+		t.Fatalf("send %q: %v", line, err)
+	}
+	_, msg, err := tc.ReadResponse(wantCode)
+	if err != nil {
+		t.Fatalf("%s: %v", line, err)
+	}
+	return msg
+}
+
+func TestHandleDataDelivers(t *testing.T) {
+	backend := &recordingBackend{}
+	srv := New("mail.example.com", backend)
+	tc := testConn(t, srv)
+
+	cmd(t, tc, 250, "EHLO client.example.com")
+	cmd(t, tc, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, tc, 250, "RCPT TO:<bob@example.com>")
+
+	if err := tc.PrintfLine("DATA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tc.ReadResponse(354); err != nil {
+		t.Fatalf("DATA: %v", err)
+	}
+	dw := tc.DotWriter()
+	io.Copy(dw, strings.NewReader("Subject: hi\r\n\r\nbody\r\n"))
+	dw.Close()
+	if _, _, err := tc.ReadResponse(250); err != nil {
+		t.Fatalf("after DATA: %v", err)
+	}
+
+	if len(backend.sessions) != 1 {
+		t.Fatalf("sessions = %d, want 1", len(backend.sessions))
+	}
+	s := backend.sessions[0]
+	if s.from != "alice@example.com" || len(s.rcpt) != 1 || s.rcpt[0] != "bob@example.com" {
+		t.Errorf("session = %+v, want from alice, rcpt [bob]", s)
+	}
+	// textproto's DotReader normalizes CRLF to LF as it unstuffs the dot
+	// encoding, so the delivered body doesn't retain wire-format line endings.
+	if string(s.data) != "Subject: hi\n\nbody\n" {
+		t.Errorf("data = %q", s.data)
+	}
+}
+
+func TestHandleDataRejectsOversizeMessage(t *testing.T) {
+	backend := &recordingBackend{}
+	srv := New("mail.example.com", backend)
+	srv.MaxMessageBytes = 10
+	tc := testConn(t, srv)
+
+	cmd(t, tc, 250, "EHLO client.example.com")
+	cmd(t, tc, 250, "MAIL FROM:<alice@example.com>")
+	cmd(t, tc, 250, "RCPT TO:<bob@example.com>")
+
+	if err := tc.PrintfLine("DATA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tc.ReadResponse(354); err != nil {
+		t.Fatalf("DATA: %v", err)
+	}
+	dw := tc.DotWriter()
+	io.Copy(dw, strings.NewReader(strings.Repeat("x", 50)+"\r\n"))
+	dw.Close()
+
+	if _, _, err := tc.ReadResponse(552); err != nil {
+		t.Fatalf("want 552 for oversize message, got: %v", err)
+	}
+
+	// The whole point of failing the read is that the backend never gets
+	// to commit a truncated message -- it must never have recorded one.
+	s := backend.sessions[0]
+	if s.data != nil {
+		t.Errorf("session recorded data %q after a rejected oversize message, want nothing committed", s.data)
+	}
+}
+
+func TestHandleRcptAllowListDropsSilently(t *testing.T) {
+	backend := &recordingBackend{}
+	srv := New("mail.example.com", backend)
+	srv.AllowList = &AllowList{addrs: map[string]bool{"bob@example.com": true}}
+	tc := testConn(t, srv)
+
+	cmd(t, tc, 250, "EHLO client.example.com")
+	cmd(t, tc, 250, "MAIL FROM:<alice@example.com>")
+	// Not on the allow-list: still 250 OK, per AllowList's blackhole
+	// behavior, but never reaches the session.
+	cmd(t, tc, 250, "RCPT TO:<eve@example.com>")
+
+	s := backend.sessions[0]
+	if len(s.rcpt) != 0 {
+		t.Errorf("rcpt = %v, want none (should have been dropped)", s.rcpt)
+	}
+}
+
+func TestHandleAuthRequiresTLSWhenConfigured(t *testing.T) {
+	backend := &recordingBackend{}
+	srv := New("mail.example.com", backend)
+	srv.Auth = AuthConfig{
+		Enabled:    true,
+		RequireTLS: true,
+		Userpass:   func(user, pass string) bool { return true },
+	}
+	tc := testConn(t, srv)
+
+	cmd(t, tc, 250, "EHLO client.example.com")
+	if err := tc.PrintfLine("AUTH PLAIN"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tc.ReadResponse(538); err != nil {
+		t.Fatalf("want 538 without TLS, got: %v", err)
+	}
+}
+
+func TestHandleDataRequiresMailAndRcpt(t *testing.T) {
+	backend := &recordingBackend{}
+	srv := New("mail.example.com", backend)
+	tc := testConn(t, srv)
+
+	cmd(t, tc, 250, "EHLO client.example.com")
+	if err := tc.PrintfLine("DATA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tc.ReadResponse(503); err != nil {
+		t.Fatalf("want 503 without MAIL/RCPT, got: %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+// errLineTooLong is returned to the connection's read loop when a peer
+// sends a command line longer than the server is willing to buffer;
+// RFC 5321 4.5.3.1.4 caps command lines at 512 octets, so a client that
+// blows well past that is either broken or hostile, and the connection is
+// simply dropped rather than risking unbounded memory growth.
+var errLineTooLong = errors.New("server: command line too long")
+
+// lineLimitConn wraps a net.Conn and fails the Read once more than max
+// bytes have gone by since the last '\n', without needing to know where
+// textproto's own internal buffering happens to land its reads.
+type lineLimitConn struct {
+	net.Conn
+	max int
+	cur int
+}
+
+func (c *lineLimitConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] == '\n' {
+			c.cur = 0
+			continue
+		}
+		c.cur++
+		if c.cur > c.max {
+			return i + 1, errLineTooLong
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// MaildirBackend delivers each accepted message into root as a Maildir++
+// file per recipient, using the conventional write-to-tmp, rename-to-new
+// sequence so a reader (an IMAP server, a procmail-style delivery agent)
+// never observes a partially written message.
+type MaildirBackend struct {
+	root string
+}
+
+// NewMaildirBackend creates root's tmp/, new/, and cur/ subdirectories if
+// they don't already exist, and returns a Backend that delivers into them.
+func NewMaildirBackend(root string) (*MaildirBackend, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0700); err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirBackend{root: root}, nil
+}
+
+// NewSession implements Backend.
+func (b *MaildirBackend) NewSession(state *ConnectionState) (Session, error) {
+	return &maildirSession{backend: b}, nil
+}
+
+type maildirSession struct {
+	backend *MaildirBackend
+	from    string
+	rcpts   []string
+}
+
+func (s *maildirSession) Mail(from string, opts MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *maildirSession) Rcpt(to string) error {
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *maildirSession) Reset() {
+	s.from = ""
+	s.rcpts = nil
+}
+
+func (s *maildirSession) Logout() error { return nil }
+
+// Data implements Session by writing one copy of the message per
+// recipient; Maildir has no notion of a single message with multiple
+// envelope recipients.
+func (s *maildirSession) Data(r io.Reader) error {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for range s.rcpts {
+		if err := s.backend.deliver(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var deliveryCounter int64
+
+func (b *MaildirBackend) deliver(body []byte) error {
+	name := uniqueName()
+	tmpPath := filepath.Join(b.root, "tmp", name)
+	newPath := filepath.Join(b.root, "new", name)
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(body); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, newPath)
+}
+
+// uniqueName returns a Maildir++ unique base name: time, PID, delivery
+// sequence, and hostname, which together are unique across both
+// concurrent deliveries on this host and restarts of this process.
+func uniqueName() string {
+	seq := atomic.AddInt64(&deliveryCounter, 1)
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%d.%d_%d.%s", time.Now().UnixNano(), os.Getpid(), seq, host)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// PipeBackend hands each accepted message to a fresh invocation of a
+// shell command, on its stdin, once per message rather than once per
+// recipient -- the command is expected to consult the SENDER and
+// RECIPIENTS environment variables if it cares about the envelope, in the
+// spirit of a sendmail(8) delivery agent invoked via a pipe alias.
+type PipeBackend struct {
+	command string
+}
+
+// NewPipeBackend returns a Backend that runs command (via "/bin/sh -c")
+// for every accepted message.
+func NewPipeBackend(command string) *PipeBackend {
+	return &PipeBackend{command: command}
+}
+
+// NewSession implements Backend.
+func (b *PipeBackend) NewSession(state *ConnectionState) (Session, error) {
+	return &pipeSession{backend: b}, nil
+}
+
+type pipeSession struct {
+	backend *PipeBackend
+	from    string
+	rcpts   []string
+}
+
+func (s *pipeSession) Mail(from string, opts MailOptions) error {
+	s.from = from
+	return nil
+}
+
+func (s *pipeSession) Rcpt(to string) error {
+	s.rcpts = append(s.rcpts, to)
+	return nil
+}
+
+func (s *pipeSession) Reset() {
+	s.from = ""
+	s.rcpts = nil
+}
+
+func (s *pipeSession) Logout() error { return nil }
+
+func (s *pipeSession) Data(r io.Reader) error {
+	cmd := exec.Command("/bin/sh", "-c", s.backend.command)
+	cmd.Env = append(os.Environ(),
+		"SENDER="+s.from,
+		"RECIPIENTS="+strings.Join(s.rcpts, " "))
+	cmd.Stdin = r
+	cmd.Stdout = io.Discard
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
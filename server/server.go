@@ -0,0 +1,101 @@
+// Package server implements a small RFC 5321 SMTP submission server. It
+// follows the Backend/Session split emersion/go-smtp uses: this package
+// owns protocol mechanics -- the line-length limiter, PIPELINING, the
+// DATA dot-stuffing reader, STARTTLS, and AUTH -- and defers only the
+// delivery decision (what to do with an accepted message) to a Backend.
+//
+// Two Backends are provided: MaildirBackend, which writes Maildir++ files,
+// and PipeBackend, which hands each message to a spawned command. Callers
+// needing something else can implement Backend/Session directly.
+package server
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// Backend constructs a Session for each MAIL command a connection issues.
+// A fresh Session is created per mail transaction (RSET and a second MAIL
+// both start a new one), mirroring how a real delivery agent would want a
+// clean slate for each message rather than accumulating state across an
+// entire connection.
+type Backend interface {
+	NewSession(state *ConnectionState) (Session, error)
+}
+
+// Session receives the commands of a single mail transaction, in order:
+// one Mail, zero or more Rcpt, then one Data. Reset ends the transaction:
+// it is called on RSET, on a new HELO/EHLO or STARTTLS, and after DATA.
+// Logout is called once when the underlying connection goes away, on the
+// Session of a transaction still open at that point; a Session that was
+// already Reset gets no Logout, and a connection that closes mid-transaction
+// calls Logout without Reset.
+type Session interface {
+	Mail(from string, opts MailOptions) error
+	Rcpt(to string) error
+	Data(r io.Reader) error
+	Reset()
+	Logout() error
+}
+
+// MailOptions carries the parameters a client attached to MAIL FROM.
+type MailOptions struct {
+	Size int64  // declared message size from the SIZE parameter, or 0
+	Body string // "7BIT" or "8BITMIME", from the BODY parameter
+}
+
+// ConnectionState describes the connection a Session belongs to.
+type ConnectionState struct {
+	RemoteAddr net.Addr
+	HeloName   string
+	TLS        bool
+	AuthUser   string // set once AUTH has succeeded, otherwise empty
+}
+
+// AuthConfig controls whether and how AUTH is offered.
+type AuthConfig struct {
+	Enabled    bool
+	RequireTLS bool // gate AUTH PLAIN/LOGIN behind STARTTLS having run
+	Userpass   func(user, password string) bool
+}
+
+// Server holds the configuration for a submission listener. The zero
+// Server is not usable; construct one with New.
+type Server struct {
+	Domain          string // used in the greeting and EHLO response
+	Backend         Backend
+	AllowList       *AllowList // nil means every recipient is accepted
+	TLSConfig       *tls.Config
+	Auth            AuthConfig
+	MaxMessageBytes int64 // 0 means no SIZE limit is advertised or enforced
+	MaxLineLength   int
+}
+
+// New returns a Server with reasonable defaults (no TLS, no AUTH, no
+// message size limit, every recipient accepted); set the exported fields
+// before calling ListenAndServe to change that.
+func New(domain string, backend Backend) *Server {
+	return &Server{
+		Domain:        domain,
+		Backend:       backend,
+		MaxLineLength: 2048,
+	}
+}
+
+// ListenAndServe accepts connections on addr until Accept fails (typically
+// because the listener was closed), serving each on its own goroutine.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(nc)
+	}
+}